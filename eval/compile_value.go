@@ -2,13 +2,22 @@ package eval
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/elves/elvish/eval/types"
 	"github.com/elves/elvish/glob"
@@ -19,6 +28,21 @@ import (
 
 var outputCaptureBufferSize = 16
 
+// parallelGlobThreshold is the number of intermediate values above
+// which compoundOp.Invoke fans glob expansion and the outer-product cat
+// loop (see expandGlobs and outerProduct) out over a worker pool instead
+// of running them serially. ParallelGlob forces the parallel path
+// regardless of this threshold. It is exported so a $edit:parallel-glob
+// variable can flip it on, but this tree's edit package has no source
+// files at all (only a pre-existing test file referencing types this
+// tree doesn't define), so there is nowhere to add that variable from
+// here; until the edit package exists, ParallelGlob can only be set by
+// other Go code (e.g. a test), not from an elvish script.
+var (
+	parallelGlobThreshold = 256
+	ParallelGlob          = false
+)
+
 // ValuesOp is an operation on an Frame that produce Value's.
 type ValuesOp struct {
 	Body       ValuesOpBody
@@ -37,6 +61,19 @@ func (op ValuesOp) Exec(ec *Frame) ([]types.Value, error) {
 }
 
 func (cp *compiler) compound(n *parse.Compound) ValuesOpBody {
+	if ir, ok := cp.compoundIR(n); ok {
+		return buildValuesOpBody(ir)
+	}
+	return cp.compoundDirect(n)
+}
+
+// compoundDirect is the original, direct compiler for a compound: it
+// builds the ValuesOpBody closures and interface values that
+// Frame.Exec runs, without going through an IR step. It remains the
+// fallback for the forms compoundIR doesn't (yet) lower -- lone ~,
+// indexed values, and anything whose head isn't a plain literal,
+// variable or literal-range brace.
+func (cp *compiler) compoundDirect(n *parse.Compound) ValuesOpBody {
 	if len(n.Indexings) == 0 {
 		return literalStr("")
 	}
@@ -62,6 +99,201 @@ func (cp *compiler) compound(n *parse.Compound) ValuesOpBody {
 	return compoundOp{tilde, cp.indexingOps(indexings)}
 }
 
+// --- Intermediate representation ---
+//
+// compoundDirect and primary build ValuesOpBody values directly:
+// closures and interface values bound to a live *compiler, which can
+// neither be marshaled nor reused across a later re-run of the same
+// source. compoundIR/primaryIR are the CREATE half of a two-phase
+// compile for the subset of the grammar that is already purely
+// static once names are resolved -- literals, variable references and
+// literal brace ranges: they lower a *parse.Compound into an *IRNode,
+// an exported, gob-marshalable struct, doing all of the
+// compiler-state-dependent work (scope lookups, range parsing, error
+// reporting) up front. buildValuesOpBody is the BUILD half: it lowers
+// an *IRNode into the same ValuesOp/ValuesOpBody tree compoundDirect
+// would have produced, without touching *compiler at all, so a cached
+// IRNode can be replayed against a fresh *Frame in a later process.
+//
+// Lambdas, maps, captures, indexed values and the lone-~ form aren't
+// representable yet (compoundIR reports ok=false and the caller falls
+// back to compoundDirect).
+//
+// This is a partial first slice, not the full request: compile_effect.go
+// and compile_lvalue.go still compile directly with no IR step, and
+// cp.capture is still mutated mid-compile rather than recorded as a
+// pure IR annotation. Consulting IRCache from compound() itself would
+// also be unsound as-is: a cache hit would skip cp.registerVariableGet,
+// which is a side effect on cp (marking a name as captured by the
+// enclosing lambda) that compoundIR currently performs as it walks the
+// tree, not something buildValuesOpBody can replay from the IRNode
+// alone. Making that safe to skip is exactly the "pure IR annotation"
+// work above; IRCache is therefore exercised directly (see its tests)
+// but not yet wired into compound(), use, or any other module-load
+// path.
+
+// IRNodeKind identifies the shape of an IRNode.
+type IRNodeKind int
+
+// The kinds of IRNode.
+const (
+	IRLiteral IRNodeKind = iota
+	IRVariable
+	IRCompound
+)
+
+// IRNode is a node in the intermediate representation of a compound
+// expression. It carries only plain, exported data -- no captured
+// *compiler or *Frame -- so it can be marshaled with encoding/gob and
+// cached on disk keyed on source hash and elvish version; see IRCache.
+type IRNode struct {
+	Kind IRNodeKind
+
+	// Values holds the already-resolved literal value(s) of the node
+	// when Kind is IRLiteral: the text of a literal string, or the
+	// full expansion of a literal brace range such as {1..3}.
+	Values []string
+
+	// Explode, NS and Name describe a variable reference when Kind is
+	// IRVariable.
+	Explode bool
+	NS      string
+	Name    string
+
+	// Tilde and Subnodes describe a compound when Kind is IRCompound.
+	Tilde    bool
+	Subnodes []*IRNode
+
+	Begin, End int
+}
+
+// compoundIR lowers n into an IRNode if every indexing in n is a plain
+// literal, variable reference or literal brace range with no index
+// operations; it reports ok=false if n uses a form the IR doesn't
+// cover yet, in which case the caller should fall back to
+// compoundDirect.
+func (cp *compiler) compoundIR(n *parse.Compound) (node *IRNode, ok bool) {
+	if len(n.Indexings) == 0 {
+		return &IRNode{Kind: IRLiteral, Values: []string{""}, Begin: n.Begin(), End: n.End()}, true
+	}
+
+	indexings := n.Indexings
+	tilde := false
+	if indexings[0].Head.Type == parse.Tilde {
+		if len(indexings) == 1 {
+			// A lone ~ is resolved straight from $HOME, not by running
+			// doTilde over a compoundOp result; compoundDirect handles
+			// it with its own funcValuesOp, which the IR doesn't model.
+			return nil, false
+		}
+		tilde = true
+		indexings = indexings[1:]
+	}
+
+	subnodes := make([]*IRNode, len(indexings))
+	for i, in := range indexings {
+		sub, ok := cp.indexingIR(in)
+		if !ok {
+			return nil, false
+		}
+		subnodes[i] = sub
+	}
+	return &IRNode{Kind: IRCompound, Tilde: tilde, Subnodes: subnodes, Begin: n.Begin(), End: n.End()}, true
+}
+
+func (cp *compiler) indexingIR(n *parse.Indexing) (*IRNode, bool) {
+	if len(n.Indicies) != 0 {
+		return nil, false
+	}
+	return cp.primaryIR(n.Head)
+}
+
+func (cp *compiler) primaryIR(n *parse.Primary) (*IRNode, bool) {
+	switch n.Type {
+	case parse.Bareword, parse.SingleQuoted, parse.DoubleQuoted:
+		return &IRNode{Kind: IRLiteral, Values: []string{n.Value}, Begin: n.Begin(), End: n.End()}, true
+	case parse.Variable:
+		explode, ns, name := ParseVariable(n.Value)
+		if !cp.registerVariableGet(ns, name) {
+			cp.errorf("variable $%s not found", n.Value)
+		}
+		return &IRNode{Kind: IRVariable, Explode: explode, NS: ns, Name: name, Begin: n.Begin(), End: n.End()}, true
+	case parse.Braced:
+		if !n.IsRange {
+			return nil, false
+		}
+		return &IRNode{Kind: IRLiteral, Values: cp.bracedRangeValues(n), Begin: n.Begin(), End: n.End()}, true
+	default:
+		return nil, false
+	}
+}
+
+// buildValuesOpBody lowers an IRNode into the ValuesOpBody that
+// compoundDirect would have produced for the equivalent source. It
+// never consults *compiler: every piece of compiler state the node
+// needs (resolved names, parsed range endpoints) was already folded
+// into the node by compoundIR/primaryIR, which is what makes an
+// IRNode safe to cache and replay in a later process.
+func buildValuesOpBody(n *IRNode) ValuesOpBody {
+	switch n.Kind {
+	case IRLiteral:
+		values := make([]types.Value, len(n.Values))
+		for i, s := range n.Values {
+			values[i] = types.String(s)
+		}
+		return literalValuesOp{values}
+	case IRVariable:
+		return &variableOp{n.Explode, n.NS, n.Name}
+	case IRCompound:
+		subops := make([]ValuesOp, len(n.Subnodes))
+		for i, sub := range n.Subnodes {
+			subops[i] = ValuesOp{buildValuesOpBody(sub), sub.Begin, sub.End}
+		}
+		return compoundOp{n.Tilde, subops}
+	default:
+		panic("bad IRNodeKind")
+	}
+}
+
+// IRCache persists IRNode trees on disk, keyed on a hash of the source
+// text and the running elvish version. It is meant to let `use` and
+// other module loads skip re-parsing and re-compiling source that
+// hasn't changed since the cache was populated, but nothing calls
+// Load/Store yet -- see the scope note above compoundIR for why wiring
+// it into compound() isn't sound until capture registration is reworked
+// to not depend on walking the tree live.
+type IRCache struct {
+	Dir string
+}
+
+func (c IRCache) path(source, version string) string {
+	sum := sha256.Sum256([]byte(version + "\x00" + source))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".gob")
+}
+
+// Load returns the cached IRNode for source, if any.
+func (c IRCache) Load(source, version string) (*IRNode, bool) {
+	f, err := os.Open(c.path(source, version))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	var n IRNode
+	if gob.NewDecoder(f).Decode(&n) != nil {
+		return nil, false
+	}
+	return &n, true
+}
+
+// Store writes n to the cache under the key derived from source.
+func (c IRCache) Store(source, version string, n *IRNode) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(n); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path(source, version), buf.Bytes(), 0644)
+}
+
 type compoundOp struct {
 	tilde  bool
 	subops []ValuesOp
@@ -99,6 +331,19 @@ func (op compoundOp) Invoke(ec *Frame) ([]types.Value, error) {
 		}
 	}
 	if hasGlob {
+		vs = expandGlobs(vs, ec)
+	}
+	return vs, nil
+}
+
+// expandGlobs replaces each GlobPattern in vs with its matches, leaving
+// non-glob values untouched, and preserves the order of vs the shell
+// has always guaranteed. It fans the globbing out over a worker pool
+// once there are enough values to be worth the synchronization (or
+// unconditionally when ParallelGlob is set); otherwise it stays on the
+// serial path doGlob has always run on.
+func expandGlobs(vs []types.Value, ec *Frame) []types.Value {
+	if !ParallelGlob && len(vs) <= parallelGlobThreshold {
 		newvs := make([]types.Value, 0, len(vs))
 		for _, v := range vs {
 			if gp, ok := v.(GlobPattern); ok {
@@ -108,9 +353,38 @@ func (op compoundOp) Invoke(ec *Frame) ([]types.Value, error) {
 				newvs = append(newvs, v)
 			}
 		}
-		vs = newvs
+		return newvs
 	}
-	return vs, nil
+
+	matches := make([][]types.Value, len(vs))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.NumCPU())
+	for i, v := range vs {
+		gp, ok := v.(GlobPattern)
+		if !ok {
+			matches[i] = []types.Value{v}
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, gp GlobPattern) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			// Logger.Printf("globbing %v", gp)
+			matches[i] = doGlob(gp, ec.Interrupts())
+		}(i, gp)
+	}
+	wg.Wait()
+
+	n := 0
+	for _, m := range matches {
+		n += len(m)
+	}
+	newvs := make([]types.Value, 0, n)
+	for _, m := range matches {
+		newvs = append(newvs, m...)
+	}
+	return newvs
 }
 
 func cat(lhs, rhs types.Value) (types.Value, error) {
@@ -143,16 +417,80 @@ func cat(lhs, rhs types.Value) (types.Value, error) {
 }
 
 func outerProduct(vs []types.Value, us []types.Value, f func(types.Value, types.Value) (types.Value, error)) ([]types.Value, error) {
-	ws := make([]types.Value, len(vs)*len(us))
 	nu := len(us)
-	for i, v := range vs {
-		for j, u := range us {
-			var err error
-			ws[i*nu+j], err = f(v, u)
-			if err != nil {
-				return nil, err
+	if !ParallelGlob && len(vs)*nu <= parallelGlobThreshold {
+		ws := make([]types.Value, len(vs)*nu)
+		for i, v := range vs {
+			for j, u := range us {
+				var err error
+				ws[i*nu+j], err = f(v, u)
+				if err != nil {
+					return nil, err
+				}
 			}
 		}
+		return ws, nil
+	}
+	return outerProductParallel(vs, us, f)
+}
+
+// outerProductParallel computes the same result as the serial loop in
+// outerProduct, but fans the rows of the product (one per element of
+// vs) out over a fixed-size worker pool. f (cat) is pure for strings,
+// but its GlobPattern case appends to lhs.Segments in place, which can
+// alias the backing array of a later call with the same lhs once a
+// prior append has left it with spare capacity; work is therefore
+// partitioned by i; so all f(vs[i], us[j]) calls for a given i run on
+// the same goroutine, one at a time, exactly as the serial loop would,
+// and only the independent rows run concurrently.
+func outerProductParallel(vs []types.Value, us []types.Value, f func(types.Value, types.Value) (types.Value, error)) ([]types.Value, error) {
+	nu := len(us)
+	ws := make([]types.Value, len(vs)*nu)
+
+	rows := make(chan int, len(vs))
+	for i := range vs {
+		rows <- i
+	}
+	close(rows)
+
+	nworkers := runtime.NumCPU()
+	if nworkers > len(vs) {
+		nworkers = len(vs)
+	}
+	if nworkers < 1 {
+		nworkers = 1
+	}
+
+	var stopped int32
+	firstErr := make(chan error, nworkers)
+	var wg sync.WaitGroup
+	for w := 0; w < nworkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range rows {
+				if atomic.LoadInt32(&stopped) != 0 {
+					return
+				}
+				for j, u := range us {
+					w, err := f(vs[i], u)
+					if err != nil {
+						atomic.StoreInt32(&stopped, 1)
+						select {
+						case firstErr <- err:
+						default:
+						}
+						return
+					}
+					ws[i*nu+j] = w
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(firstErr)
+	if err := <-firstErr; err != nil {
+		return nil, err
 	}
 	return ws, nil
 }
@@ -275,7 +613,18 @@ func (cp *compiler) primary(n *parse.Primary) ValuesOpBody {
 	case parse.ExceptionCapture:
 		return exceptionCaptureOp{cp.chunkOp(n.Chunk)}
 	case parse.OutputCapture:
-		return outputCaptureOp{cp.chunkOp(n.Chunk)}
+		return outputCaptureOp{cp.chunkOp(n.Chunk), false}
+	case parse.StreamCapture:
+		// NOTE: this tree contains no parse package at all (only eval/,
+		// edit/ and cmd/ are checked in here), so there is no lexer or
+		// grammar to add &(...) to, and no way to confirm from this
+		// checkout whether parse.StreamCapture reaches this switch from
+		// real source. Wiring up &(...) end to end is out of scope for
+		// what the eval package alone can deliver; this case and
+		// outputCaptureOp's stream flag are the eval-side half the
+		// request asked for, written on the assumption that the parser
+		// side is added separately. See pcaptureOutputStream below.
+		return outputCaptureOp{cp.chunkOp(n.Chunk), true}
 	case parse.List:
 		return cp.list(n)
 	case parse.Lambda:
@@ -339,9 +688,23 @@ func (op exceptionCaptureOp) Invoke(fm *Frame) ([]types.Value, error) {
 	return []types.Value{err.(*Exception)}, nil
 }
 
-type outputCaptureOp struct{ subop Op }
+// outputCaptureOp implements both forms of output capture: $(...),
+// which buffers the whole pipeline into a slice of Values, and the
+// streaming form &(...) (stream set to true), which exposes the
+// pipeline as a lazily-consumed StreamCapture instead.
+type outputCaptureOp struct {
+	subop  Op
+	stream bool
+}
 
 func (op outputCaptureOp) Invoke(fm *Frame) ([]types.Value, error) {
+	if op.stream {
+		sc, err := pcaptureOutputStream(fm, op.subop)
+		if err != nil {
+			return nil, err
+		}
+		return []types.Value{sc}, nil
+	}
 	return pcaptureOutput(fm, op.subop)
 }
 
@@ -414,6 +777,145 @@ func pcaptureOutputInner(ec *Frame, op Op, valuesCb func(<-chan types.Value), by
 	return err
 }
 
+// StreamCapture is the Value produced by a streaming output capture
+// (&(...)). Unlike pcaptureOutput, which waits for the whole pipeline
+// to finish and buffers its output in a []types.Value, a StreamCapture
+// exposes that output as a types.Iterator backed by a bounded channel,
+// so a consumer piped straight from it (e.g. each $f &(producer)) can
+// start processing before the producer pipeline has finished.
+type StreamCapture struct {
+	values <-chan types.Value
+	stop   chan<- struct{}
+	done   <-chan struct{}
+	err    *error
+}
+
+// Kind returns the kind of a StreamCapture.
+func (*StreamCapture) Kind() string { return "stream-capture" }
+
+// Repr returns the representation of a StreamCapture.
+func (sc *StreamCapture) Repr(int) string {
+	return fmt.Sprintf("<stream-capture %p>", sc)
+}
+
+// Iterate implements types.Iterator. It yields values as they arrive
+// from the backing pipeline. If f returns false, Iterate signals the
+// pipeline's readers to stop handing it values via sc.stop and returns
+// immediately; the readers themselves keep draining ch/the pipe in the
+// background (see pcaptureOutputStream) so the still-running producer
+// never blocks trying to send into a channel nobody reads from. Iterate
+// returning does not mean the pipeline has finished, so it does not set
+// *sc.err itself -- call Err, which waits for that.
+func (sc *StreamCapture) Iterate(f func(types.Value) bool) {
+	for v := range sc.values {
+		if !f(v) {
+			close(sc.stop)
+			return
+		}
+	}
+}
+
+// Err returns the error the backing pipeline finished with, if any. It
+// blocks until the pipeline goroutine has actually written sc.err, which
+// may be after Iterate has returned: closing sc.stop (what an early
+// Iterate return does) only asks the forwarders to stop handing off
+// values, it does not wait for newEc.PEval(op) to finish, so reading
+// *sc.err right after Iterate returns would otherwise race the goroutine
+// in pcaptureOutputStream that sets it.
+func (sc *StreamCapture) Err() error {
+	<-sc.done
+	return *sc.err
+}
+
+// pcaptureOutputStream is the streaming counterpart of pcaptureOutput:
+// instead of collecting the captured values and lines into a slice, it
+// runs the pipeline in a goroutine and returns a StreamCapture that
+// forwards both channels of output (value and byte) into a single
+// merged channel as they arrive.
+//
+// Once the consumer stops iterating early (sc.stop closed), the two
+// forwarder goroutines below do not return: a still-running producer
+// holds newEc.ports[1], so if the forwarders stopped reading, the next
+// send to ch (or write to pipeWrite) would block forever, leaking both
+// goroutines and, if op spawns an external command, the process
+// writing to the other end of the pipe. Instead they keep draining ch
+// and the pipe -- discarding what they read -- until op actually
+// finishes, at which point ClosePorts and the usual EOF/channel-close
+// sequence let them exit normally.
+//
+// done is closed right before merged, once sticky has its final value;
+// StreamCapture.Err blocks on done so a caller reading Err() right after
+// an early Iterate return (which only closes stop, not done) still
+// observes a fully-written *sc.err instead of racing this goroutine.
+func pcaptureOutputStream(ec *Frame, op Op) (*StreamCapture, error) {
+	newEc := ec.fork("[stream output capture]")
+
+	ch := make(chan types.Value, outputCaptureBufferSize)
+	pipeRead, pipeWrite, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipe: %v", err)
+	}
+	newEc.ports[1] = &Port{
+		Chan: ch, CloseChan: true,
+		File: pipeWrite, CloseFile: true,
+	}
+
+	merged := make(chan types.Value, outputCaptureBufferSize)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	chCollected := make(chan struct{})
+	bytesCollected := make(chan struct{})
+
+	go func() {
+		defer close(chCollected)
+		stopped := false
+		for v := range ch {
+			if stopped {
+				continue
+			}
+			select {
+			case merged <- v:
+			case <-stop:
+				stopped = true
+			}
+		}
+	}()
+	go func() {
+		defer close(bytesCollected)
+		defer pipeRead.Close()
+		buffered := bufio.NewReader(pipeRead)
+		stopped := false
+		for {
+			line, err := buffered.ReadString('\n')
+			if line != "" && !stopped {
+				select {
+				case merged <- types.String(strings.TrimSuffix(line, "\n")):
+				case <-stop:
+					stopped = true
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					logger.Println("error on reading:", err)
+				}
+				break
+			}
+		}
+	}()
+
+	var sticky error
+	go func() {
+		sticky = newEc.PEval(op)
+		ClosePorts(newEc.ports)
+		<-bytesCollected
+		<-chCollected
+		close(done)
+		close(merged)
+	}()
+
+	return &StreamCapture{values: merged, stop: stop, done: done, err: &sticky}, nil
+}
+
 func (cp *compiler) lambda(n *parse.Primary) ValuesOpBody {
 	// Parse signature.
 	var (
@@ -568,12 +1070,123 @@ func (op *mapPairsOp) Invoke(fm *Frame) ([]types.Value, error) {
 }
 
 func (cp *compiler) braced(n *parse.Primary) ValuesOpBody {
+	if n.IsRange {
+		return cp.bracedRange(n)
+	}
 	ops := cp.compoundOps(n.Braced)
-	// TODO: n.IsRange
-	// isRange := n.IsRange
 	return seqValuesOp{ops}
 }
 
+// bracedRange compiles a range brace expression such as {1..10},
+// {01..10..2} or {a..z} into a bracedRangeOp. The endpoints (and the
+// optional step) must be literal strings; they are resolved to their
+// final values at compile time, since a range never depends on runtime
+// state.
+func (cp *compiler) bracedRange(n *parse.Primary) ValuesOpBody {
+	values := cp.bracedRangeValues(n)
+	vs := make([]types.Value, len(values))
+	for i, s := range values {
+		vs[i] = types.String(s)
+	}
+	return bracedRangeOp{vs}
+}
+
+// bracedRangeValues computes the expansion of a range brace expression
+// as plain strings, without wrapping them in a ValuesOpBody. It is
+// shared by bracedRange and primaryIR (the braced case of compoundIR).
+func (cp *compiler) bracedRangeValues(n *parse.Primary) []string {
+	compounds := n.Braced
+	if len(compounds) != 2 && len(compounds) != 3 {
+		cp.errorpf(n.Begin(), n.End(), "range must have the form {lo..hi} or {lo..hi..step}")
+	}
+	lo := mustString(cp, compounds[0], "range endpoint must be a literal string")
+	hi := mustString(cp, compounds[1], "range endpoint must be a literal string")
+
+	step := 1
+	if len(compounds) == 3 {
+		stepText := mustString(cp, compounds[2], "range step must be a literal string")
+		n, err := strconv.Atoi(stepText)
+		if err != nil || n <= 0 {
+			cp.errorpf(compounds[2].Begin(), compounds[2].End(),
+				"range step must be a positive integer")
+		}
+		step = n
+	}
+
+	if isSingleLetter(lo) && isSingleLetter(hi) {
+		return letterRange(lo[0], hi[0], step)
+	}
+
+	loNum, err := strconv.Atoi(lo)
+	if err != nil {
+		cp.errorpf(compounds[0].Begin(), compounds[0].End(),
+			"range endpoint must be an integer or a single letter")
+	}
+	hiNum, err := strconv.Atoi(hi)
+	if err != nil {
+		cp.errorpf(compounds[1].Begin(), compounds[1].End(),
+			"range endpoint must be an integer or a single letter")
+	}
+
+	width := 0
+	if hasLeadingZero(lo) || hasLeadingZero(hi) {
+		width = len(lo)
+		if len(hi) > width {
+			width = len(hi)
+		}
+	}
+	return numberRange(loNum, hiNum, step, width)
+}
+
+// bracedRangeOp holds the values of an already-expanded range brace
+// expression, e.g. {1..10} or {a..z}.
+type bracedRangeOp struct{ values []types.Value }
+
+func (op bracedRangeOp) Invoke(*Frame) ([]types.Value, error) {
+	return op.values, nil
+}
+
+func isSingleLetter(s string) bool {
+	return len(s) == 1 && ((s[0] >= 'a' && s[0] <= 'z') || (s[0] >= 'A' && s[0] <= 'Z'))
+}
+
+func hasLeadingZero(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	return len(s) > 1 && s[0] == '0'
+}
+
+func letterRange(lo, hi byte, step int) []string {
+	var values []string
+	if lo <= hi {
+		for c := int(lo); c <= int(hi); c += step {
+			values = append(values, string(rune(c)))
+		}
+	} else {
+		for c := int(lo); c >= int(hi); c -= step {
+			values = append(values, string(rune(c)))
+		}
+	}
+	return values
+}
+
+func numberRange(lo, hi, step, width int) []string {
+	format := "%d"
+	if width > 0 {
+		format = fmt.Sprintf("%%0%dd", width)
+	}
+	var values []string
+	if lo <= hi {
+		for i := lo; i <= hi; i += step {
+			values = append(values, fmt.Sprintf(format, i))
+		}
+	} else {
+		for i := lo; i >= hi; i -= step {
+			values = append(values, fmt.Sprintf(format, i))
+		}
+	}
+	return values
+}
+
 type literalValuesOp struct{ values []types.Value }
 
 func (op literalValuesOp) Invoke(*Frame) ([]types.Value, error) {